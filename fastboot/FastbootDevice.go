@@ -1,6 +1,7 @@
 package fastboot
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
@@ -30,54 +31,28 @@ var Error = struct {
 }
 
 type FastbootDevice struct {
+	transport Transport
+
+	// Device and Context are only populated when the device was found
+	// over USB; they are nil for a TCP-connected device. Releasing the
+	// claimed interface goes through Close(), not a public field, so it
+	// can't be called twice and panic.
 	Device  *gousb.Device
 	Context *gousb.Context
-	In      *gousb.InEndpoint
-	Out     *gousb.OutEndpoint
-	Unclaim func()
-}
-
-func FindDevices() ([]*FastbootDevice, error) {
-	ctx := gousb.NewContext()
-	var fastbootDevices []*FastbootDevice
-	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
-		for _, cfg := range desc.Configs {
-			for _, ifc := range cfg.Interfaces {
-				for _, alt := range ifc.AltSettings {
-					return alt.Protocol == 0x03 && alt.Class == 0xff && alt.SubClass == 0x42
-				}
-			}
-		}
-		return true
-	})
 
-	if err != nil && len(devs) == 0 {
-		return nil, err
-	}
+	onProgress func(sent, total int64)
+}
 
-	for _, dev := range devs {
-		intf, done, err := dev.DefaultInterface()
-		if err != nil {
-			continue
-		}
-		inEndpoint, err := intf.InEndpoint(0x81)
-		if err != nil {
-			continue
-		}
-		outEndpoint, err := intf.OutEndpoint(0x01)
-		if err != nil {
-			continue
-		}
-		fastbootDevices = append(fastbootDevices, &FastbootDevice{
-			Device:  dev,
-			Context: ctx,
-			In:      inEndpoint,
-			Out:     outEndpoint,
-			Unclaim: done,
-		})
-	}
+// SetProgress registers a callback invoked as data is transferred to the
+// device: per chunk inside Download, and per sub-image inside FlashSparse.
+// Pass nil to stop reporting progress.
+func (d *FastbootDevice) SetProgress(fn func(sent, total int64)) {
+	d.onProgress = fn
+}
 
-	return fastbootDevices, nil
+func FindDevices() ([]*FastbootDevice, error) {
+	devs, _, err := FindDevicesFunc(nil)
+	return devs, err
 }
 
 func FindDevice(serial string) (*FastbootDevice, error) {
@@ -101,41 +76,57 @@ func FindDevice(serial string) (*FastbootDevice, error) {
 	return &FastbootDevice{}, Error.DeviceNotFound
 }
 
+// DialTCP connects to a fastboot-over-TCP endpoint (fastbootd, an emulator,
+// ...) at addr, which should be a "host:port" pair such as
+// "192.168.1.23:5554".
+func DialTCP(addr string) (*FastbootDevice, error) {
+	t, err := dialTCPTransport(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &FastbootDevice{transport: t}, nil
+}
+
 func (d *FastbootDevice) Close() {
-	d.Unclaim()
-	d.Device.Close()
-	d.Context.Close()
+	d.transport.Close()
+}
+
+// Reset issues a USB port reset, which can clear a stuck endpoint without
+// tearing down the surrounding Context. It's only supported on devices
+// found over USB.
+func (d *FastbootDevice) Reset() error {
+	t, ok := d.transport.(*usbTransport)
+	if !ok {
+		return errNotUSBTransport
+	}
+	return t.Reset()
 }
 
 func (d *FastbootDevice) Send(data []byte) error {
-	_, err := d.Out.Write(data)
-	return err
+	return d.transport.Send(data)
 }
 
 func (d *FastbootDevice) GetMaxPacketSize() (int, error) {
-	return d.Out.Desc.MaxPacketSize, nil
+	return d.transport.MaxPacketSize(), nil
 }
 
-func (d *FastbootDevice) Recv() (FastbootResponseStatus, []byte, error) {
-	var data []byte
-	buf := make([]byte, d.In.Desc.MaxPacketSize)
-	n, err := d.In.Read(buf)
-	if err != nil {
-		return Status.FAIL, []byte{}, err
-	}
-	data = append(data, buf[:n]...)
-	var status FastbootResponseStatus
-	switch string(data[:4]) {
-	case "OKAY":
-		status = Status.OKAY
-	case "FAIL":
-		status = Status.FAIL
-	case "DATA":
-		status = Status.DATA
-	case "INFO":
-		status = Status.INFO
+// Recv reads one logical fastboot response, looping past any INFO messages
+// until it reaches the terminating OKAY/FAIL/DATA status. The accumulated
+// INFO payloads are returned alongside it so every command can surface them
+// instead of dropping them on the floor.
+func (d *FastbootDevice) Recv() (FastbootResponseStatus, []byte, []string, error) {
+	var info []string
+	for {
+		status, data, err := d.transport.Recv()
+		if err != nil {
+			return Status.FAIL, []byte{}, info, err
+		}
+		if status == Status.INFO {
+			info = append(info, string(data))
+			continue
+		}
+		return status, data, info, nil
 	}
-	return status, data[4:], nil
 }
 
 func (d *FastbootDevice) GetVar(variable string) (string, error) {
@@ -143,7 +134,7 @@ func (d *FastbootDevice) GetVar(variable string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	status, resp, err := d.Recv()
+	status, resp, _, err := d.Recv()
 	if status == Status.FAIL {
 		err = Error.VarNotFound
 	}
@@ -164,7 +155,7 @@ func (d *FastbootDevice) BootImage(data []byte) error {
 		return err
 	}
 
-	status, data, err := d.Recv()
+	status, data, _, err := d.Recv()
 	switch {
 	case status != Status.OKAY:
 		return fmt.Errorf("failed to boot image: %s %s", status, data)
@@ -175,6 +166,10 @@ func (d *FastbootDevice) BootImage(data []byte) error {
 }
 
 func (d *FastbootDevice) Flash(partition string, data []byte) error {
+	if isSparseImage(data) {
+		return d.FlashSparse(partition, bytes.NewReader(data))
+	}
+
 	err := d.Download(data)
 	if err != nil {
 		return err
@@ -185,7 +180,7 @@ func (d *FastbootDevice) Flash(partition string, data []byte) error {
 		return err
 	}
 
-	status, data, err := d.Recv()
+	status, data, _, err := d.Recv()
 	switch {
 	case status != Status.OKAY:
 		return fmt.Errorf("failed to flash image: %s %s", status, data)
@@ -203,7 +198,7 @@ func (d *FastbootDevice) Download(data []byte) error {
 		return err
 	}
 
-	status, _, err := d.Recv()
+	status, _, _, err := d.Recv()
 	switch {
 	case status != Status.DATA:
 		return fmt.Errorf("failed to start data phase: %s", status)
@@ -218,12 +213,23 @@ func (d *FastbootDevice) Download(data []byte) error {
 		if end > data_size {
 			end = data_size
 		}
-		err := d.Send(data[i:end])
+		chunk := data[i:end]
+		sem := currentGlobalByteSemaphore()
+		if sem != nil {
+			sem.acquire(len(chunk))
+		}
+		err := d.Send(chunk)
+		if sem != nil {
+			sem.release(len(chunk))
+		}
 		if err != nil {
 			return err
 		}
+		if d.onProgress != nil {
+			d.onProgress(int64(end), int64(data_size))
+		}
 	}
-	status, data, err = d.Recv()
+	status, data, _, err = d.Recv()
 	switch {
 	case status != Status.OKAY:
 		return fmt.Errorf("failed to finish data phase: %s %s", status, data)
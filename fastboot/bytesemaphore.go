@@ -0,0 +1,94 @@
+package fastboot
+
+import "sync"
+
+// byteSemaphore is a weighted semaphore sized in bytes rather than tokens,
+// modeled on syncthing's byteSemaphore. It lets any number of goroutines
+// share a bounded in-flight byte budget, e.g. so flashing N devices
+// concurrently doesn't exhaust host memory or USB bandwidth.
+type byteSemaphore struct {
+	max       int
+	available int
+	mut       sync.Mutex
+	cond      *sync.Cond
+}
+
+func newByteSemaphore(max int) *byteSemaphore {
+	if max < 0 {
+		max = 0
+	}
+	s := &byteSemaphore{max: max, available: max}
+	s.cond = sync.NewCond(&s.mut)
+	return s
+}
+
+// acquire blocks until n bytes are available and reserves them. A request
+// larger than the semaphore's capacity is clamped to that capacity so it
+// can never deadlock waiting for more than will ever be available.
+func (s *byteSemaphore) acquire(n int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if n > s.max {
+		n = s.max
+	}
+	for s.available < n {
+		s.cond.Wait()
+	}
+	s.available -= n
+}
+
+// release returns n bytes to the budget, never raising the available amount
+// above the current capacity, and wakes any waiters.
+func (s *byteSemaphore) release(n int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.available += n
+	if s.available > s.max {
+		s.available = s.max
+	}
+	s.cond.Broadcast()
+}
+
+// setCapacity resizes the budget, adjusting the currently available amount
+// by the same delta so in-flight acquires are unaffected.
+func (s *byteSemaphore) setCapacity(max int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.available += max - s.max
+	s.max = max
+	s.cond.Broadcast()
+}
+
+// globalByteSemaphore bounds the total bytes in flight across every
+// FastbootDevice in the process; nil means unbounded. Set it with
+// SetGlobalByteBudget. globalByteSemaphoreMu guards the pointer itself
+// (not the byteSemaphore it points to, which has its own locking), since
+// SetGlobalByteBudget may be called while Download is concurrently reading
+// it from other goroutines.
+var (
+	globalByteSemaphoreMu sync.Mutex
+	globalByteSemaphore   *byteSemaphore
+)
+
+// SetGlobalByteBudget caps the total number of bytes that may be in flight
+// to devices at once (across every goroutine/device in the process). This
+// lets callers flash many devices concurrently, e.g. via FlashAll, without
+// overrunning host memory or shared USB bandwidth. It's safe to call while
+// flashes are already in progress.
+func SetGlobalByteBudget(maxBytes int) {
+	globalByteSemaphoreMu.Lock()
+	defer globalByteSemaphoreMu.Unlock()
+	if globalByteSemaphore == nil {
+		globalByteSemaphore = newByteSemaphore(maxBytes)
+		return
+	}
+	globalByteSemaphore.setCapacity(maxBytes)
+}
+
+// currentGlobalByteSemaphore returns the current global budget, if any, for
+// use around a single acquire/release pair.
+func currentGlobalByteSemaphore() *byteSemaphore {
+	globalByteSemaphoreMu.Lock()
+	defer globalByteSemaphoreMu.Unlock()
+	return globalByteSemaphore
+}
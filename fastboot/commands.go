@@ -0,0 +1,73 @@
+package fastboot
+
+import "fmt"
+
+// sendSimpleCommand sends a command that expects a single terminating OKAY
+// with no data phase, the pattern shared by most of the control commands
+// below.
+func (d *FastbootDevice) sendSimpleCommand(cmd string) error {
+	if err := d.Send([]byte(cmd)); err != nil {
+		return err
+	}
+	status, data, _, err := d.Recv()
+	switch {
+	case status != Status.OKAY:
+		return fmt.Errorf("failed to run %q: %s %s", cmd, status, data)
+	case err != nil:
+		return err
+	}
+	return nil
+}
+
+// Reboot reboots the device out of the bootloader into the normal boot
+// target.
+func (d *FastbootDevice) Reboot() error {
+	return d.sendSimpleCommand("reboot")
+}
+
+// RebootBootloader reboots the device back into the bootloader.
+func (d *FastbootDevice) RebootBootloader() error {
+	return d.sendSimpleCommand("reboot-bootloader")
+}
+
+// RebootFastboot reboots the device into userspace fastboot (fastbootd).
+func (d *FastbootDevice) RebootFastboot() error {
+	return d.sendSimpleCommand("reboot-fastboot")
+}
+
+// RebootRecovery reboots the device into recovery.
+func (d *FastbootDevice) RebootRecovery() error {
+	return d.sendSimpleCommand("reboot-recovery")
+}
+
+// Continue resumes the normal boot process, equivalent to "fastboot
+// continue".
+func (d *FastbootDevice) Continue() error {
+	return d.sendSimpleCommand("continue")
+}
+
+// Erase erases the named partition.
+func (d *FastbootDevice) Erase(partition string) error {
+	return d.sendSimpleCommand(fmt.Sprintf("erase:%s", partition))
+}
+
+// SetActive marks slot as the active slot on an A/B device.
+func (d *FastbootDevice) SetActive(slot string) error {
+	return d.sendSimpleCommand(fmt.Sprintf("set_active:%s", slot))
+}
+
+// Oem runs an OEM-specific command and returns every INFO line the device
+// sent back before the terminating OKAY/FAIL.
+func (d *FastbootDevice) Oem(cmd string) ([]string, error) {
+	if err := d.Send([]byte(fmt.Sprintf("oem %s", cmd))); err != nil {
+		return nil, err
+	}
+	status, data, info, err := d.Recv()
+	switch {
+	case status != Status.OKAY:
+		return info, fmt.Errorf("failed to run oem %s: %s %s", cmd, status, data)
+	case err != nil:
+		return info, err
+	}
+	return info, nil
+}
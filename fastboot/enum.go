@@ -0,0 +1,157 @@
+package fastboot
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/google/gousb"
+)
+
+// DeviceInfo describes a device discovered during enumeration, including a
+// handful of fastboot variables fetched through a short-lived claim so
+// callers can filter on them without opening the device themselves.
+type DeviceInfo struct {
+	Serial  string
+	Product string
+	VID     gousb.ID
+	PID     gousb.ID
+	Bus     int
+	Address int
+
+	// Vars holds the fastboot variables fetched during enumeration:
+	// product, variant, slot-count and current-slot.
+	Vars map[string]string
+}
+
+// enumDeviceVars lists the fastboot variables pre-fetched into
+// DeviceInfo.Vars for every enumerated device.
+var enumDeviceVars = []string{"product", "variant", "slot-count", "current-slot"}
+
+// EnumError records a device that was found on the bus but couldn't be
+// opened as a fastboot device, so callers can surface or log it instead of
+// it being silently dropped.
+type EnumError struct {
+	Bus     int
+	Address int
+	Err     error
+}
+
+func (e EnumError) Error() string {
+	return fmt.Sprintf("bus %d addr %d: %v", e.Bus, e.Address, e.Err)
+}
+
+func (e EnumError) Unwrap() error { return e.Err }
+
+// FindDevicesFunc enumerates fastboot devices on USB, claiming each one long
+// enough to read its DeviceInfo, and returns those for which match reports
+// true (or every device, if match is nil). Devices whose interface can't be
+// claimed or whose endpoints can't be opened are reported as EnumErrors
+// rather than skipped without a trace. A device that fails to open at all
+// is not reported as an EnumError: the open error is returned only when no
+// device could be opened, and is dropped otherwise.
+func FindDevicesFunc(match func(info DeviceInfo) bool) ([]*FastbootDevice, []EnumError, error) {
+	ctx := gousb.NewContext()
+	cc := newCtxCloser(ctx)
+	var fastbootDevices []*FastbootDevice
+	var enumErrors []EnumError
+
+	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		for _, cfg := range desc.Configs {
+			for _, ifc := range cfg.Interfaces {
+				for _, alt := range ifc.AltSettings {
+					return alt.Protocol == 0x03 && alt.Class == 0xff && alt.SubClass == 0x42
+				}
+			}
+		}
+		return true
+	})
+	if err != nil && len(devs) == 0 {
+		cc.Close()
+		return nil, nil, err
+	}
+
+	for _, dev := range devs {
+		intf, done, err := claimDefaultInterface(dev)
+		if err != nil {
+			enumErrors = append(enumErrors, EnumError{Bus: dev.Desc.Bus, Address: dev.Desc.Address, Err: err})
+			continue
+		}
+		inEndpoint, err := intf.InEndpoint(0x81)
+		if err != nil {
+			done()
+			enumErrors = append(enumErrors, EnumError{Bus: dev.Desc.Bus, Address: dev.Desc.Address, Err: err})
+			continue
+		}
+		outEndpoint, err := intf.OutEndpoint(0x01)
+		if err != nil {
+			done()
+			enumErrors = append(enumErrors, EnumError{Bus: dev.Desc.Bus, Address: dev.Desc.Address, Err: err})
+			continue
+		}
+
+		cc.addRef()
+		fbDev := &FastbootDevice{
+			transport: &usbTransport{
+				dev:     dev,
+				ctx:     cc,
+				in:      inEndpoint,
+				out:     outEndpoint,
+				unclaim: done,
+			},
+			Device:  dev,
+			Context: ctx,
+		}
+
+		info := DeviceInfo{
+			VID:     dev.Desc.Vendor,
+			PID:     dev.Desc.Product,
+			Bus:     dev.Desc.Bus,
+			Address: dev.Desc.Address,
+		}
+		if serial, err := dev.SerialNumber(); err == nil {
+			info.Serial = serial
+		}
+		for _, name := range enumDeviceVars {
+			value, err := fbDev.GetVar(name)
+			if err != nil {
+				continue
+			}
+			if info.Vars == nil {
+				info.Vars = make(map[string]string, len(enumDeviceVars))
+			}
+			info.Vars[name] = value
+			if name == "product" {
+				info.Product = value
+			}
+		}
+
+		if match != nil && !match(info) {
+			fbDev.Close()
+			continue
+		}
+
+		fastbootDevices = append(fastbootDevices, fbDev)
+	}
+
+	// Release enumeration's own reference. The context is only actually
+	// closed once every returned device (and this call) has released its
+	// reference, so devices still in use by a caller driving several of
+	// them concurrently (e.g. FlashAll) are unaffected by each other's
+	// Close calls.
+	cc.Close()
+
+	return fastbootDevices, enumErrors, nil
+}
+
+// FindDevicesByRegex is sugar over FindDevicesFunc that matches devices
+// whose serial number or product variable matches pattern, borrowing the
+// "-dev" regex filter from go-mtpfs.
+func FindDevicesByRegex(pattern string) ([]*FastbootDevice, []EnumError, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	return FindDevicesFunc(func(info DeviceInfo) bool {
+		return re.MatchString(info.Serial) || re.MatchString(info.Product)
+	})
+}
@@ -0,0 +1,112 @@
+package fastboot
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/timoxa0/gofastboot/fastboot/sparse"
+)
+
+// sparseMagic is the 4-byte little-endian magic at the start of an Android
+// sparse image, see sparse.Magic.
+const sparseMagic = sparse.Magic
+
+// FlashSparse flashes a partition from an Android sparse image, splitting it
+// into as many sub-images as needed to stay under the device's
+// max-download-size.
+func (d *FastbootDevice) FlashSparse(partition string, r io.Reader) error {
+	maxDownloadSize, err := d.maxDownloadSize()
+	if err != nil {
+		return err
+	}
+
+	img, err := sparse.Decode(r)
+	if err != nil {
+		return err
+	}
+
+	subImages, err := sparse.Split(img, maxDownloadSize)
+	if err != nil {
+		return err
+	}
+
+	encoded := make([][]byte, len(subImages))
+	var totalBytes int64
+	for i, sub := range subImages {
+		var buf []byte
+		w := &sliceWriter{buf: &buf}
+		if err := sparse.Encode(w, sub); err != nil {
+			return fmt.Errorf("failed to encode sparse sub-image %d/%d: %w", i+1, len(subImages), err)
+		}
+		encoded[i] = buf
+		totalBytes += int64(len(buf))
+	}
+
+	// Download reports progress per USB chunk of a single sub-image, in
+	// bytes; that's not a unit FlashSparse's caller can mix with a
+	// sub-image count. Suppress it here and report our own progress in
+	// bytes sent across the whole sparse image instead, so the callback
+	// sees one consistent (sent, total) signal throughout the flash.
+	userProgress := d.onProgress
+	d.onProgress = nil
+	defer func() { d.onProgress = userProgress }()
+
+	var sentBytes int64
+	for i, buf := range encoded {
+		if err := d.flashChunk(partition, buf, i, len(encoded)); err != nil {
+			return err
+		}
+		sentBytes += int64(len(buf))
+		if userProgress != nil {
+			userProgress(sentBytes, totalBytes)
+		}
+	}
+
+	return nil
+}
+
+func (d *FastbootDevice) flashChunk(partition string, data []byte, index, total int) error {
+	if err := d.Download(data); err != nil {
+		return fmt.Errorf("failed to download sparse sub-image %d/%d: %w", index+1, total, err)
+	}
+	if err := d.Send([]byte(fmt.Sprintf("flash:%s", partition))); err != nil {
+		return err
+	}
+	status, resp, _, err := d.Recv()
+	switch {
+	case status != Status.OKAY:
+		return fmt.Errorf("failed to flash sparse sub-image %d/%d: %s %s", index+1, total, status, resp)
+	case err != nil:
+		return err
+	}
+	return nil
+}
+
+func (d *FastbootDevice) maxDownloadSize() (int, error) {
+	v, err := d.GetVar("max-download-size")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query max-download-size: %w", err)
+	}
+	n, err := strconv.ParseInt(v, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected max-download-size %q: %w", v, err)
+	}
+	return int(n), nil
+}
+
+// sliceWriter is a minimal io.Writer over a growable byte slice, used to
+// build sub-images in memory before downloading them.
+type sliceWriter struct {
+	buf *[]byte
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	*w.buf = append(*w.buf, p...)
+	return len(p), nil
+}
+
+func isSparseImage(data []byte) bool {
+	return len(data) >= 4 && binary.LittleEndian.Uint32(data[:4]) == sparseMagic
+}
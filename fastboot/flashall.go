@@ -0,0 +1,31 @@
+package fastboot
+
+import "sync"
+
+// FlashAll flashes the same partition on every device in devices, running
+// up to concurrency flashes at a time. It returns one error per device,
+// indexed the same as devices, nil where the flash succeeded. Combine with
+// SetGlobalByteBudget to bound the total bytes in flight across all of
+// them regardless of concurrency.
+func FlashAll(devices []*FastbootDevice, partition string, data []byte, concurrency int) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	errs := make([]error, len(devices))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, dev := range devices {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, dev *FastbootDevice) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = dev.Flash(partition, data)
+		}(i, dev)
+	}
+
+	wg.Wait()
+	return errs
+}
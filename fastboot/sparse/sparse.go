@@ -0,0 +1,139 @@
+// Package sparse implements the Android sparse image format used to
+// distribute large partition images (system, super, userdata, ...) in a
+// form that can be streamed to a device without inflating them to their
+// full size on disk first.
+package sparse
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic is the 4-byte little-endian magic that begins every sparse image.
+const Magic uint32 = 0xED26FF3A
+
+const (
+	majorVersion    = 1
+	fileHeaderSize  = 28
+	chunkHeaderSize = 12
+)
+
+// ChunkType identifies the kind of data a Chunk carries.
+type ChunkType uint16
+
+const (
+	ChunkTypeRaw      ChunkType = 0xCAC1
+	ChunkTypeFill     ChunkType = 0xCAC2
+	ChunkTypeDontCare ChunkType = 0xCAC3
+	ChunkTypeCRC32    ChunkType = 0xCAC4
+)
+
+var ErrBadMagic = errors.New("sparse: bad magic")
+
+// Chunk is a single record of a sparse image. Blocks is always the number
+// of BlockSize-sized blocks the chunk expands to on the device. Data holds
+// the chunk's raw payload: the literal bytes for a RAW chunk, the 4-byte
+// fill value for a FILL chunk, the 4-byte checksum for a CRC32 chunk, and
+// nothing for a DONTCARE chunk.
+type Chunk struct {
+	Type   ChunkType
+	Blocks uint32
+	Data   []byte
+}
+
+// Image is a decoded sparse image: a block size plus the ordered chunks
+// that, laid end to end, reconstruct the full unsparsed image.
+type Image struct {
+	BlockSize uint32
+	Chunks    []Chunk
+}
+
+// Blocks returns the total number of BlockSize blocks the image expands to.
+func (img *Image) Blocks() uint32 {
+	var total uint32
+	for _, c := range img.Chunks {
+		total += c.Blocks
+	}
+	return total
+}
+
+// Decode parses a sparse image from r.
+func Decode(r io.Reader) (*Image, error) {
+	var hdr [fileHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("sparse: reading header: %w", err)
+	}
+
+	magic := binary.LittleEndian.Uint32(hdr[0:4])
+	if magic != Magic {
+		return nil, ErrBadMagic
+	}
+	major := binary.LittleEndian.Uint16(hdr[4:6])
+	if major != majorVersion {
+		return nil, fmt.Errorf("sparse: unsupported major version %d", major)
+	}
+	blockSize := binary.LittleEndian.Uint32(hdr[12:16])
+	totalChunks := binary.LittleEndian.Uint32(hdr[20:24])
+
+	img := &Image{BlockSize: blockSize}
+	for i := uint32(0); i < totalChunks; i++ {
+		var chdr [chunkHeaderSize]byte
+		if _, err := io.ReadFull(r, chdr[:]); err != nil {
+			return nil, fmt.Errorf("sparse: reading chunk %d header: %w", i, err)
+		}
+		chunkType := ChunkType(binary.LittleEndian.Uint16(chdr[0:2]))
+		blocks := binary.LittleEndian.Uint32(chdr[4:8])
+		totalSize := binary.LittleEndian.Uint32(chdr[8:12])
+
+		dataSize := int(totalSize) - chunkHeaderSize
+		if dataSize < 0 {
+			return nil, fmt.Errorf("sparse: chunk %d has invalid total_sz %d", i, totalSize)
+		}
+		data := make([]byte, dataSize)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("sparse: reading chunk %d data: %w", i, err)
+		}
+
+		img.Chunks = append(img.Chunks, Chunk{Type: chunkType, Blocks: blocks, Data: data})
+	}
+
+	return img, nil
+}
+
+// Encode writes img back out in sparse image format.
+func Encode(w io.Writer, img *Image) error {
+	var hdr [fileHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], Magic)
+	binary.LittleEndian.PutUint16(hdr[4:6], majorVersion)
+	binary.LittleEndian.PutUint16(hdr[6:8], 0)
+	binary.LittleEndian.PutUint16(hdr[8:10], fileHeaderSize)
+	binary.LittleEndian.PutUint16(hdr[10:12], chunkHeaderSize)
+	binary.LittleEndian.PutUint32(hdr[12:16], img.BlockSize)
+	binary.LittleEndian.PutUint32(hdr[16:20], img.Blocks())
+	binary.LittleEndian.PutUint32(hdr[20:24], uint32(len(img.Chunks)))
+	binary.LittleEndian.PutUint32(hdr[24:28], 0) // image checksum, unused since major version 1.0
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+
+	for _, c := range img.Chunks {
+		var chdr [chunkHeaderSize]byte
+		binary.LittleEndian.PutUint16(chdr[0:2], uint16(c.Type))
+		binary.LittleEndian.PutUint16(chdr[2:4], 0)
+		binary.LittleEndian.PutUint32(chdr[4:8], c.Blocks)
+		binary.LittleEndian.PutUint32(chdr[8:12], uint32(chunkHeaderSize+len(c.Data)))
+		if _, err := w.Write(chdr[:]); err != nil {
+			return err
+		}
+		if len(c.Data) > 0 {
+			if _, err := w.Write(c.Data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package sparse
+
+import "fmt"
+
+// Split divides img into a sequence of sparse images, each of which encodes
+// (via Encode) to at most maxSize bytes. RAW chunks are cut at block
+// boundaries and re-emitted with a fresh chunk header in each sub-image;
+// FILL/DONTCARE/CRC32 chunks are never split, since their encoded size
+// doesn't depend on how many blocks they cover.
+func Split(img *Image, maxSize int) ([]*Image, error) {
+	minSize := fileHeaderSize + chunkHeaderSize
+	if maxSize < minSize {
+		return nil, fmt.Errorf("sparse: maxSize %d too small to hold even one chunk header", maxSize)
+	}
+
+	var result []*Image
+	cur := &Image{BlockSize: img.BlockSize}
+	curSize := fileHeaderSize
+
+	finish := func() {
+		if len(cur.Chunks) > 0 {
+			result = append(result, cur)
+		}
+		cur = &Image{BlockSize: img.BlockSize}
+		curSize = fileHeaderSize
+	}
+
+	for _, c := range img.Chunks {
+		if c.Type != ChunkTypeRaw {
+			cost := chunkHeaderSize + len(c.Data)
+			if curSize+cost > maxSize {
+				finish()
+			}
+			if curSize+cost > maxSize {
+				return nil, fmt.Errorf("sparse: maxSize %d too small to hold a %d-byte chunk", maxSize, cost)
+			}
+			cur.Chunks = append(cur.Chunks, c)
+			curSize += cost
+			continue
+		}
+
+		blocksPerChunk := len(c.Data) / int(img.BlockSize)
+		remainingBlocks := c.Blocks
+		offset := 0
+		for remainingBlocks > 0 {
+			avail := maxSize - curSize - chunkHeaderSize
+			availBlocks := avail / int(img.BlockSize)
+			if availBlocks <= 0 {
+				finish()
+				avail = maxSize - curSize - chunkHeaderSize
+				availBlocks = avail / int(img.BlockSize)
+				if availBlocks <= 0 {
+					return nil, fmt.Errorf("sparse: maxSize %d too small to hold a single %d-byte block", maxSize, img.BlockSize)
+				}
+			}
+
+			take := int(remainingBlocks)
+			if take > availBlocks {
+				take = availBlocks
+			}
+			if take > blocksPerChunk-offset {
+				take = blocksPerChunk - offset
+			}
+
+			data := c.Data[offset*int(img.BlockSize) : (offset+take)*int(img.BlockSize)]
+			cur.Chunks = append(cur.Chunks, Chunk{Type: ChunkTypeRaw, Blocks: uint32(take), Data: data})
+			curSize += chunkHeaderSize + len(data)
+
+			offset += take
+			remainingBlocks -= uint32(take)
+		}
+	}
+
+	finish()
+	return result, nil
+}
@@ -0,0 +1,138 @@
+package sparse
+
+import (
+	"bytes"
+	"testing"
+)
+
+func mustDecode(t *testing.T, data []byte) *Image {
+	t.Helper()
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	return img
+}
+
+func mustEncode(t *testing.T, img *Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, img); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// expand reconstructs the full raw byte stream a set of chunks expands to
+// on the device: RAW chunks contribute their literal bytes, FILL chunks
+// repeat their 4-byte value for the whole chunk, and DONTCARE chunks
+// contribute zero bytes (their real content is, by definition, unspecified,
+// so zero is as good a placeholder as any for comparing two chunk lists
+// that describe the same image).
+func expand(chunks []Chunk, blockSize uint32) []byte {
+	var out []byte
+	for _, c := range chunks {
+		size := int(c.Blocks) * int(blockSize)
+		switch c.Type {
+		case ChunkTypeRaw:
+			out = append(out, c.Data...)
+		case ChunkTypeFill:
+			for i := 0; i < size; i += len(c.Data) {
+				out = append(out, c.Data...)
+			}
+		case ChunkTypeDontCare:
+			out = append(out, make([]byte, size)...)
+		}
+	}
+	return out
+}
+
+func testImage() *Image {
+	const blockSize = 512
+	raw1 := bytes.Repeat([]byte{0xAA}, 4*blockSize)
+	raw2 := bytes.Repeat([]byte{0xBB}, 3*blockSize)
+	return &Image{
+		BlockSize: blockSize,
+		Chunks: []Chunk{
+			{Type: ChunkTypeRaw, Blocks: 4, Data: raw1},
+			{Type: ChunkTypeFill, Blocks: 6, Data: []byte{0x01, 0x02, 0x03, 0x04}},
+			{Type: ChunkTypeDontCare, Blocks: 10, Data: nil},
+			{Type: ChunkTypeRaw, Blocks: 3, Data: raw2},
+		},
+	}
+}
+
+func TestSplitRoundTrip(t *testing.T) {
+	img := testImage()
+
+	// maxSize picked so no single sub-image can hold every chunk, forcing
+	// Split to actually cut the RAW chunks at block boundaries.
+	maxSize := fileHeaderSize + 3*(chunkHeaderSize+2*int(img.BlockSize))
+
+	subImages, err := Split(img, maxSize)
+	if err != nil {
+		t.Fatalf("Split: %v", err)
+	}
+	if len(subImages) < 2 {
+		t.Fatalf("expected Split to produce more than one sub-image, got %d", len(subImages))
+	}
+
+	var gotChunks []Chunk
+	for i, sub := range subImages {
+		encoded := mustEncode(t, sub)
+		if len(encoded) > maxSize {
+			t.Errorf("sub-image %d encodes to %d bytes, exceeds maxSize %d", i, len(encoded), maxSize)
+		}
+
+		decoded := mustDecode(t, encoded)
+		if decoded.BlockSize != sub.BlockSize || len(decoded.Chunks) != len(sub.Chunks) {
+			t.Fatalf("sub-image %d: Decode(Encode(sub)) did not round-trip: got %+v, want %+v", i, decoded, sub)
+		}
+		for j, c := range decoded.Chunks {
+			want := sub.Chunks[j]
+			if c.Type != want.Type || c.Blocks != want.Blocks || !bytes.Equal(c.Data, want.Data) {
+				t.Fatalf("sub-image %d chunk %d: Decode(Encode(sub)) did not round-trip: got %+v, want %+v", i, j, c, want)
+			}
+		}
+
+		gotChunks = append(gotChunks, decoded.Chunks...)
+	}
+
+	want := expand(img.Chunks, img.BlockSize)
+	got := expand(gotChunks, img.BlockSize)
+	if !bytes.Equal(want, got) {
+		t.Fatalf("reassembled image content does not match original: got %d bytes, want %d bytes", len(got), len(want))
+	}
+}
+
+func TestSplitExactBoundary(t *testing.T) {
+	img := testImage()
+
+	// Sized to hold exactly the first RAW chunk (4 blocks) and nothing
+	// more, so the first sub-image fills to exactly maxSize and the FILL
+	// chunk after it has to start a new sub-image rather than run past it.
+	maxSize := fileHeaderSize + chunkHeaderSize + 4*int(img.BlockSize)
+
+	subImages, err := Split(img, maxSize)
+	if err != nil {
+		t.Fatalf("Split: %v", err)
+	}
+	if len(subImages[0].Chunks) != 1 || subImages[0].Chunks[0].Blocks != 4 {
+		t.Fatalf("expected the first sub-image to hold exactly the 4-block RAW chunk, got %+v", subImages[0].Chunks)
+	}
+	if encoded := mustEncode(t, subImages[0]); len(encoded) != maxSize {
+		t.Fatalf("expected the first sub-image to encode to exactly maxSize (%d), got %d", maxSize, len(encoded))
+	}
+}
+
+func TestSplitMaxSizeTooSmall(t *testing.T) {
+	img := testImage()
+
+	if _, err := Split(img, fileHeaderSize+chunkHeaderSize-1); err == nil {
+		t.Fatal("expected an error when maxSize can't even hold a chunk header, got nil")
+	}
+
+	if _, err := Split(img, fileHeaderSize+chunkHeaderSize+int(img.BlockSize)/2); err == nil {
+		t.Fatal("expected an error when maxSize can't hold a single whole block, got nil")
+	}
+}
@@ -0,0 +1,238 @@
+package fastboot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/google/gousb"
+)
+
+// Transport abstracts the wire that fastboot protocol messages travel over,
+// so the higher-level FastbootDevice methods work the same whether the
+// device is attached over USB or reachable via fastboot-over-TCP (fastbootd,
+// emulators, ...).
+type Transport interface {
+	Send(data []byte) error
+	Recv() (FastbootResponseStatus, []byte, error)
+	MaxPacketSize() int
+	Close() error
+}
+
+func parseStatus(data []byte) (FastbootResponseStatus, []byte) {
+	if len(data) < 4 {
+		return "", data
+	}
+	var status FastbootResponseStatus
+	switch string(data[:4]) {
+	case "OKAY":
+		status = Status.OKAY
+	case "FAIL":
+		status = Status.FAIL
+	case "DATA":
+		status = Status.DATA
+	case "INFO":
+		status = Status.INFO
+	}
+	return status, data[4:]
+}
+
+// ctxCloser reference-counts a *gousb.Context shared by every device
+// returned from one enumeration call (see FindDevicesFunc). Closing a
+// gousb.Context while a sibling device built from the same enumeration is
+// still in use would pull the libusb context out from under it, and closing
+// it twice panics, so the context is only actually closed once every
+// referencing usbTransport (plus the enumeration call itself, which holds
+// its own reference until enumeration finishes) has released it.
+type ctxCloser struct {
+	ctx  *gousb.Context
+	mu   sync.Mutex
+	refs int
+}
+
+// newCtxCloser wraps ctx with an initial reference belonging to the
+// enumeration call that created it; the caller must release it with Close
+// once enumeration completes, in addition to every usbTransport doing the
+// same as it's closed.
+func newCtxCloser(ctx *gousb.Context) *ctxCloser {
+	return &ctxCloser{ctx: ctx, refs: 1}
+}
+
+// addRef registers an additional owner of ctx, e.g. a newly built
+// usbTransport.
+func (c *ctxCloser) addRef() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refs++
+}
+
+// Close releases this owner's reference and closes the underlying context
+// once every reference has been released.
+func (c *ctxCloser) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refs--
+	if c.refs > 0 {
+		return nil
+	}
+	return c.ctx.Close()
+}
+
+type usbTransport struct {
+	dev       *gousb.Device
+	ctx       *ctxCloser
+	in        *gousb.InEndpoint
+	out       *gousb.OutEndpoint
+	unclaim   func()
+	unclaimed bool
+}
+
+func (t *usbTransport) Send(data []byte) error {
+	_, err := t.out.Write(data)
+	return err
+}
+
+func (t *usbTransport) Recv() (FastbootResponseStatus, []byte, error) {
+	buf := make([]byte, t.in.Desc.MaxPacketSize)
+	n, err := t.in.Read(buf)
+	if err != nil {
+		return Status.FAIL, []byte{}, err
+	}
+	status, payload := parseStatus(buf[:n])
+	return status, payload, nil
+}
+
+func (t *usbTransport) MaxPacketSize() int {
+	return t.out.Desc.MaxPacketSize
+}
+
+func (t *usbTransport) Close() error {
+	if t.unclaimed {
+		return nil
+	}
+	t.unclaimed = true
+	t.unclaim()
+	err := t.dev.Close()
+	if cerr := t.ctx.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (t *usbTransport) Reset() error {
+	return t.dev.Reset()
+}
+
+// maxClaimAttempts/claimRetryDelay bound the darwin claim-retry loop below.
+const (
+	maxClaimAttempts = 3
+	claimRetryDelay  = 200 * time.Millisecond
+)
+
+// claimDefaultInterface claims dev's default interface, working around a
+// macOS quirk (seen on Big Sur/Monterey) where claiming fails with an
+// access/busy error unless the kernel driver is detached first and, even
+// then, sometimes needs the device reset before a retry succeeds.
+func claimDefaultInterface(dev *gousb.Device) (*gousb.Interface, func(), error) {
+	_ = dev.SetAutoDetach(true)
+
+	intf, done, err := dev.DefaultInterface()
+	if err == nil || runtime.GOOS != "darwin" {
+		return intf, done, err
+	}
+
+	for attempt := 1; attempt < maxClaimAttempts && isClaimRetryable(err); attempt++ {
+		_ = dev.Reset()
+		time.Sleep(claimRetryDelay)
+		intf, done, err = dev.DefaultInterface()
+	}
+	return intf, done, err
+}
+
+func isClaimRetryable(err error) bool {
+	var uerr gousb.Error
+	if !errors.As(err, &uerr) {
+		return false
+	}
+	return uerr == gousb.ErrorAccess || uerr == gousb.ErrorBusy
+}
+
+// tcpMagic is exchanged once at the start of a fastboot-over-TCP session,
+// see fastbootd's TcpTransport::Handshake.
+const tcpMagic = "FB01"
+
+// tcpTransport speaks the fastboot-over-TCP protocol used by fastbootd and
+// emulators: a one-time 4-byte magic handshake, after which every message in
+// either direction is a big-endian uint64 byte count followed by that many
+// payload bytes.
+type tcpTransport struct {
+	conn net.Conn
+}
+
+func dialTCPTransport(addr string) (*tcpTransport, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tcpTransport{conn: conn}
+	if err := t.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *tcpTransport) handshake() error {
+	if _, err := t.conn.Write([]byte(tcpMagic)); err != nil {
+		return err
+	}
+	reply := make([]byte, len(tcpMagic))
+	if _, err := io.ReadFull(t.conn, reply); err != nil {
+		return err
+	}
+	if string(reply) != tcpMagic {
+		return fmt.Errorf("fastboot-over-tcp: unexpected handshake reply %q", reply)
+	}
+	return nil
+}
+
+func (t *tcpTransport) Send(data []byte) error {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(data)))
+	if _, err := t.conn.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := t.conn.Write(data)
+	return err
+}
+
+func (t *tcpTransport) Recv() (FastbootResponseStatus, []byte, error) {
+	var length [8]byte
+	if _, err := io.ReadFull(t.conn, length[:]); err != nil {
+		return Status.FAIL, nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint64(length[:]))
+	if _, err := io.ReadFull(t.conn, data); err != nil {
+		return Status.FAIL, nil, err
+	}
+	status, payload := parseStatus(data)
+	return status, payload, nil
+}
+
+func (t *tcpTransport) MaxPacketSize() int {
+	// There's no packet boundary over the TCP framing; fall back to the
+	// same chunk size Download already uses for USB.
+	return 0x40040
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+var errNotUSBTransport = errors.New("operation requires a USB transport")
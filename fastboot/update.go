@@ -0,0 +1,180 @@
+package fastboot
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// After reboot-bootloader the device disconnects and re-enumerates on USB;
+// these bound how long Update waits for it to come back before giving up.
+const (
+	bootloaderReconnectPoll    = 500 * time.Millisecond
+	bootloaderReconnectTimeout = 30 * time.Second
+)
+
+// updateFlashOrder is the standard partition flashing order used by
+// Android's update.zip/OTA tooling.
+var updateFlashOrder = []string{"bootloader", "radio", "boot", "recovery", "system", "vendor"}
+
+// Update flashes an Android factory update package (an android-info.txt
+// alongside a set of "<partition>.img" files, bundled as a zip), verifying
+// any "require" constraints in android-info.txt against the device's
+// fastboot variables before touching any partition. The bootloader and
+// radio are flashed in separate stages with a reboot-bootloader between
+// them, since a new bootloader must be running before the radio and later
+// partitions are sent. Only the partitions listed in updateFlashOrder are
+// flashed, in that order; any other image in the package is skipped.
+func (d *FastbootDevice) Update(zipData []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		return fmt.Errorf("failed to open update package: %w", err)
+	}
+
+	infoFile := findZipFile(zr, "android-info.txt")
+	if infoFile == nil {
+		return fmt.Errorf("update package is missing android-info.txt")
+	}
+	info, err := readZipFile(infoFile)
+	if err != nil {
+		return fmt.Errorf("failed to read android-info.txt: %w", err)
+	}
+	if err := d.checkUpdateRequirements(string(info)); err != nil {
+		return err
+	}
+
+	for i, partition := range updateFlashOrder {
+		imgFile := findZipFile(zr, partition+".img")
+		if imgFile == nil {
+			continue
+		}
+		img, err := readZipFile(imgFile)
+		if err != nil {
+			return fmt.Errorf("failed to read %s.img: %w", partition, err)
+		}
+		if err := d.Flash(partition, img); err != nil {
+			return fmt.Errorf("failed to flash %s: %w", partition, err)
+		}
+
+		if partition == "bootloader" && i+1 < len(updateFlashOrder) {
+			if err := d.rebootAndReacquire(); err != nil {
+				return fmt.Errorf("failed to reboot into the new bootloader: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// rebootAndReacquire reboots the device into its (just-flashed) bootloader
+// and, for a USB-attached device, waits for it to re-enumerate and swaps d's
+// transport over to the new handle so subsequent Flash calls don't run
+// against the stale, now-disconnected one.
+func (d *FastbootDevice) rebootAndReacquire() error {
+	ut, isUSB := d.transport.(*usbTransport)
+	var serial string
+	if isUSB {
+		serial, _ = ut.dev.SerialNumber()
+	}
+
+	if err := d.RebootBootloader(); err != nil {
+		return err
+	}
+
+	if !isUSB || serial == "" {
+		// Nothing to re-enumerate against (TCP transport, or a USB
+		// device without a serial number to match on).
+		return nil
+	}
+
+	reconnected, err := waitForDeviceBySerial(serial, bootloaderReconnectTimeout)
+	if err != nil {
+		return fmt.Errorf("device %q did not come back after reboot-bootloader: %w", serial, err)
+	}
+
+	// The old transport's device disconnected when it rebooted; release its
+	// interface claim, handle and libusb context reference before
+	// forgetting it, rather than leaking them.
+	d.transport.Close()
+
+	d.transport = reconnected.transport
+	d.Device = reconnected.Device
+	d.Context = reconnected.Context
+	return nil
+}
+
+// waitForDeviceBySerial polls FindDevice until the given serial shows back
+// up on the bus or timeout elapses.
+func waitForDeviceBySerial(serial string, timeout time.Duration) (*FastbootDevice, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		dev, err := FindDevice(serial)
+		if err == nil {
+			return dev, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(bootloaderReconnectPoll)
+	}
+}
+
+// checkUpdateRequirements verifies every "require key=value[|value...]" line
+// in android-info.txt against the device's fastboot variables.
+func (d *FastbootDevice) checkUpdateRequirements(androidInfo string) error {
+	for _, line := range strings.Split(androidInfo, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "require ") {
+			continue
+		}
+		key, allowed, ok := strings.Cut(strings.TrimPrefix(line, "require "), "=")
+		if !ok {
+			continue
+		}
+
+		variable := key
+		if key == "board" {
+			variable = "product"
+		}
+
+		actual, err := d.GetVar(variable)
+		if err != nil {
+			return fmt.Errorf("failed to verify require %s: %w", key, err)
+		}
+		if !containsString(strings.Split(allowed, "|"), actual) {
+			return fmt.Errorf("device %s %q does not satisfy required %s (%s)", variable, actual, key, allowed)
+		}
+	}
+	return nil
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+func findZipFile(zr *zip.Reader, name string) *zip.File {
+	for _, f := range zr.File {
+		if path.Base(f.Name) == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}